@@ -0,0 +1,84 @@
+package runsqs
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BackoffStrategy determines how long a consumer should wait before retrying an
+// operation (a receive, delete, or visibility change) that failed with a retryable or
+// throttled error.
+type BackoffStrategy interface {
+	// NextDelay returns how long to wait before the next attempt, given the number of
+	// attempts already made (the first retry is attempt 1) and the error that triggered
+	// the retry.
+	NextDelay(attempt int, err error) time.Duration
+}
+
+// ConstantBackoff always waits the same amount of time between retries. This matches
+// the behavior consumers had before BackoffStrategy was introduced.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+// NextDelay returns Delay regardless of attempt or err.
+func (b ConstantBackoff) NextDelay(attempt int, err error) time.Duration {
+	return b.Delay
+}
+
+// ExponentialBackoff grows the delay by Factor on every attempt, starting at Base and
+// never exceeding Max.
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Factor float64
+}
+
+// NextDelay returns Base * Factor^(attempt-1), capped at Max.
+func (b ExponentialBackoff) NextDelay(attempt int, err error) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := time.Duration(float64(b.Base) * math.Pow(b.Factor, float64(attempt-1)))
+	if b.Max > 0 && delay > b.Max {
+		return b.Max
+	}
+	return delay
+}
+
+// DecorrelatedJitterBackoff implements the "decorrelated jitter" algorithm described in
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/. It spreads
+// retries out further than full/equal jitter, which helps avoid retry storms against a
+// throttled SQS API.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+
+	mu   sync.Mutex
+	last time.Duration
+}
+
+// NextDelay returns a random delay in [Base, 3*max(last, Base)], capped at Max, where
+// last is the delay this method returned previously.
+func (b *DecorrelatedJitterBackoff) NextDelay(attempt int, err error) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	prev := b.last
+	if prev < b.Base {
+		prev = b.Base
+	}
+	upper := prev * 3
+	delay := b.Base + time.Duration(rand.Int63n(int64(upper-b.Base)+1))
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+	b.last = delay
+	return delay
+}
+
+// defaultBackoff is used by consumers that don't configure a BackoffStrategy, and
+// preserves the fixed 1 second retry delay consumers used before BackoffStrategy existed.
+var defaultBackoff BackoffStrategy = ConstantBackoff{Delay: 1 * time.Second}
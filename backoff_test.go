@@ -0,0 +1,51 @@
+package runsqs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff_NextDelay(t *testing.T) {
+	b := ConstantBackoff{Delay: 5 * time.Second}
+	if got := b.NextDelay(1, errors.New("test")); got != 5*time.Second {
+		t.Fatalf("expected 5s, got %v", got)
+	}
+	if got := b.NextDelay(10, errors.New("test")); got != 5*time.Second {
+		t.Fatalf("expected 5s regardless of attempt, got %v", got)
+	}
+}
+
+func TestExponentialBackoff_NextDelay(t *testing.T) {
+	b := ExponentialBackoff{Base: 1 * time.Second, Max: 10 * time.Second, Factor: 2}
+
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second}, // would be 16s, capped at Max
+	}
+	for _, c := range cases {
+		if got := b.NextDelay(c.attempt, errors.New("test")); got != c.want {
+			t.Errorf("attempt %d: expected %v, got %v", c.attempt, c.want, got)
+		}
+	}
+}
+
+func TestDecorrelatedJitterBackoff_NextDelay(t *testing.T) {
+	b := &DecorrelatedJitterBackoff{Base: 1 * time.Second, Max: 5 * time.Second}
+
+	for i := 0; i < 100; i++ {
+		delay := b.NextDelay(i+1, errors.New("test"))
+		if delay < b.Base {
+			t.Fatalf("delay %v is below Base %v", delay, b.Base)
+		}
+		if delay > b.Max {
+			t.Fatalf("delay %v exceeds Max %v", delay, b.Max)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package runsqs
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// MessageContext carries the raw SQS attributes for the message currently being
+// consumed. SQSMessageConsumer.ConsumeMessage only receives the message body, so
+// decorators that need more than that (tracing, dead-letter policies) read it back out
+// of ctx with MessageContextFromContext.
+type MessageContext struct {
+	Attributes        map[string]*string
+	MessageAttributes map[string]*sqs.MessageAttributeValue
+}
+
+type messageContextKey struct{}
+
+// withMessageContext returns a child context carrying msgCtx, retrievable with
+// MessageContextFromContext.
+func withMessageContext(ctx context.Context, msgCtx MessageContext) context.Context {
+	return context.WithValue(ctx, messageContextKey{}, msgCtx)
+}
+
+// MessageContextFromContext returns the MessageContext attached to ctx by the consumer,
+// and whether one was present.
+func MessageContextFromContext(ctx context.Context) (MessageContext, bool) {
+	msgCtx, ok := ctx.Value(messageContextKey{}).(MessageContext)
+	return msgCtx, ok
+}
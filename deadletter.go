@@ -0,0 +1,64 @@
+package runsqs
+
+import (
+	"context"
+	"strconv"
+)
+
+// approximateReceiveCountAttribute is the SQS message attribute name carrying how many
+// times a message has been received. DefaultSQSQueueConsumer/SmartSQSConsumer both
+// include it in their ReceiveMessage AttributeNames, so it's always present on ctx for
+// WithDeadLetterPolicy to read.
+const approximateReceiveCountAttribute = "ApproximateReceiveCount"
+
+// DLQPublisher forwards a message body, along with the error that killed it, to a
+// dead-letter destination once WithDeadLetterPolicy gives up retrying it.
+type DLQPublisher interface {
+	PublishDeadLetter(ctx context.Context, message []byte, cause error) error
+}
+
+// WithDeadLetterPolicy returns a Decorator that stops retrying a message once its
+// ApproximateReceiveCount attribute reaches maxReceives: instead of returning the
+// underlying error (which would cause a retry), it forwards the message and error to dlq
+// and returns the result of that forward, which is nil when it succeeds so the message
+// is deleted rather than received again.
+func WithDeadLetterPolicy(dlq DLQPublisher, maxReceives int) Decorator {
+	return func(next SQSMessageConsumer) SQSMessageConsumer {
+		return &deadLetterConsumer{next: next, dlq: dlq, maxReceives: maxReceives}
+	}
+}
+
+type deadLetterConsumer struct {
+	next        SQSMessageConsumer
+	dlq         DLQPublisher
+	maxReceives int
+}
+
+func (c *deadLetterConsumer) ConsumeMessage(ctx context.Context, message []byte) error {
+	e := c.next.ConsumeMessage(ctx, message)
+	if e == nil {
+		return nil
+	}
+	if approximateReceiveCount(ctx) < c.maxReceives {
+		return e
+	}
+	return c.dlq.PublishDeadLetter(ctx, message, e)
+}
+
+// approximateReceiveCount reads ApproximateReceiveCount out of the MessageContext
+// attached to ctx by the consumer, returning 0 if it's missing or unparseable.
+func approximateReceiveCount(ctx context.Context) int {
+	msgCtx, ok := MessageContextFromContext(ctx)
+	if !ok {
+		return 0
+	}
+	raw, ok := msgCtx.Attributes[approximateReceiveCountAttribute]
+	if !ok || raw == nil {
+		return 0
+	}
+	count, e := strconv.Atoi(*raw)
+	if e != nil {
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,72 @@
+package runsqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/mock/gomock"
+	"gotest.tools/assert"
+)
+
+type fakeDLQPublisher struct {
+	published []byte
+	cause     error
+}
+
+func (f *fakeDLQPublisher) PublishDeadLetter(ctx context.Context, message []byte, cause error) error {
+	f.published = message
+	f.cause = cause
+	return nil
+}
+
+func TestWithDeadLetterPolicy_RetriesUntilMaxReceives(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConsumer := NewMockSQSMessageConsumer(ctrl)
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("hello")).Return(errors.New("boom"))
+
+	dlq := &fakeDLQPublisher{}
+	decorated := WithDeadLetterPolicy(dlq, 3)(mockConsumer)
+
+	ctx := withMessageContext(context.Background(), MessageContext{
+		Attributes: map[string]*string{"ApproximateReceiveCount": aws.String("2")},
+	})
+
+	e := decorated.ConsumeMessage(ctx, []byte("hello"))
+	assert.ErrorContains(t, e, "boom")
+	assert.Assert(t, dlq.published == nil)
+}
+
+func TestWithDeadLetterPolicy_ForwardsToDLQAfterMaxReceives(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConsumer := NewMockSQSMessageConsumer(ctrl)
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("hello")).Return(errors.New("boom"))
+
+	dlq := &fakeDLQPublisher{}
+	decorated := WithDeadLetterPolicy(dlq, 3)(mockConsumer)
+
+	ctx := withMessageContext(context.Background(), MessageContext{
+		Attributes: map[string]*string{"ApproximateReceiveCount": aws.String("3")},
+	})
+
+	e := decorated.ConsumeMessage(ctx, []byte("hello"))
+	assert.NilError(t, e)
+	assert.Equal(t, string(dlq.published), "hello")
+	assert.ErrorContains(t, dlq.cause, "boom")
+}
+
+func TestWithDeadLetterPolicy_SuccessNeverReachesDLQ(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConsumer := NewMockSQSMessageConsumer(ctrl)
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("hello")).Return(nil)
+
+	dlq := &fakeDLQPublisher{}
+	decorated := WithDeadLetterPolicy(dlq, 3)(mockConsumer)
+
+	assert.NilError(t, decorated.ConsumeMessage(context.Background(), []byte("hello")))
+	assert.Assert(t, dlq.published == nil)
+}
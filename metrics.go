@@ -0,0 +1,97 @@
+package runsqs
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusMetrics holds the metrics WithPrometheusMetrics records against a consumer.
+// Construct one with NewPrometheusMetrics, which also registers every metric.
+type PrometheusMetrics struct {
+	Received   prometheus.Counter
+	Consumed   prometheus.Counter
+	Failed     prometheus.Counter
+	Retried    prometheus.Counter
+	AckLatency prometheus.Histogram
+	InFlight   prometheus.Gauge
+}
+
+// NewPrometheusMetrics builds a PrometheusMetrics under the given namespace/subsystem
+// and registers its metrics with reg.
+func NewPrometheusMetrics(reg prometheus.Registerer, namespace, subsystem string) *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		Received: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_received_total",
+			Help:      "Total number of messages handed to ConsumeMessage.",
+		}),
+		Consumed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_consumed_total",
+			Help:      "Total number of messages consumed without error.",
+		}),
+		Failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_failed_total",
+			Help:      "Total number of messages that returned a non-retryable error.",
+		}),
+		Retried: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_retried_total",
+			Help:      "Total number of messages that returned a RetryableConsumerError.",
+		}),
+		AckLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "ack_latency_seconds",
+			Help:      "Time spent inside ConsumeMessage, per message.",
+		}),
+		InFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_in_flight",
+			Help:      "Number of messages currently inside ConsumeMessage.",
+		}),
+	}
+	reg.MustRegister(m.Received, m.Consumed, m.Failed, m.Retried, m.AckLatency, m.InFlight)
+	return m
+}
+
+// WithPrometheusMetrics returns a Decorator that records received/consumed/failed/
+// retried counts, ack latency, and an in-flight gauge around ConsumeMessage.
+func WithPrometheusMetrics(metrics *PrometheusMetrics) Decorator {
+	return func(next SQSMessageConsumer) SQSMessageConsumer {
+		return &prometheusMetricsConsumer{next: next, metrics: metrics}
+	}
+}
+
+type prometheusMetricsConsumer struct {
+	next    SQSMessageConsumer
+	metrics *PrometheusMetrics
+}
+
+func (c *prometheusMetricsConsumer) ConsumeMessage(ctx context.Context, message []byte) error {
+	c.metrics.Received.Inc()
+	c.metrics.InFlight.Inc()
+	defer c.metrics.InFlight.Dec()
+
+	start := time.Now()
+	e := c.next.ConsumeMessage(ctx, message)
+	c.metrics.AckLatency.Observe(time.Since(start).Seconds())
+
+	switch e.(type) {
+	case nil:
+		c.metrics.Consumed.Inc()
+	case RetryableConsumerError:
+		c.metrics.Retried.Inc()
+	default:
+		c.metrics.Failed.Inc()
+	}
+	return e
+}
@@ -0,0 +1,34 @@
+package runsqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/assert"
+)
+
+func TestWithPrometheusMetrics_CountsOutcomes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	metrics := NewPrometheusMetrics(prometheus.NewRegistry(), "runsqs", "test")
+	decorated := WithPrometheusMetrics(metrics)(mockConsumer)
+
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("ok")).Return(nil)
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("retry")).Return(RetryableConsumerError{VisibilityTimeout: 5})
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("fail")).Return(errors.New("boom"))
+
+	assert.NilError(t, decorated.ConsumeMessage(context.Background(), []byte("ok")))
+	assert.ErrorContains(t, decorated.ConsumeMessage(context.Background(), []byte("retry")), "")
+	assert.ErrorContains(t, decorated.ConsumeMessage(context.Background(), []byte("fail")), "boom")
+
+	assert.Equal(t, int(testutil.ToFloat64(metrics.Received)), 3)
+	assert.Equal(t, int(testutil.ToFloat64(metrics.Consumed)), 1)
+	assert.Equal(t, int(testutil.ToFloat64(metrics.Retried)), 1)
+	assert.Equal(t, int(testutil.ToFloat64(metrics.Failed)), 1)
+}
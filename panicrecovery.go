@@ -0,0 +1,42 @@
+package runsqs
+
+import (
+	"context"
+	"fmt"
+
+	logger "github.com/asecurityteam/logevent"
+)
+
+// WithPanicRecovery returns a Decorator that recovers a panic from ConsumeMessage and
+// converts it into an error instead of crashing the worker goroutine. When retryable is
+// true the recovered panic is surfaced as a RetryableConsumerError with the given
+// visibilityTimeout, so the message is retried; otherwise it's surfaced as a plain error,
+// so the message is deleted like any other terminal failure. Either way, the recovered
+// value is logged through log so a panicking handler isn't retried silently.
+func WithPanicRecovery(retryable bool, visibilityTimeout int64, log logger.Logger) Decorator {
+	return func(next SQSMessageConsumer) SQSMessageConsumer {
+		return &panicRecoveryConsumer{next: next, retryable: retryable, visibilityTimeout: visibilityTimeout, logger: log}
+	}
+}
+
+type panicRecoveryConsumer struct {
+	next              SQSMessageConsumer
+	retryable         bool
+	visibilityTimeout int64
+	logger            logger.Logger
+}
+
+func (c *panicRecoveryConsumer) ConsumeMessage(ctx context.Context, message []byte) (e error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := fmt.Errorf("recovered panic in ConsumeMessage: %v", r)
+			c.logger.Error(panicErr.Error())
+			if c.retryable {
+				e = RetryableConsumerError{VisibilityTimeout: c.visibilityTimeout}
+			} else {
+				e = panicErr
+			}
+		}
+	}()
+	return c.next.ConsumeMessage(ctx, message)
+}
@@ -0,0 +1,59 @@
+package runsqs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"gotest.tools/assert"
+)
+
+func TestWithPanicRecovery_TerminalConvertsPanicToError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConsumer := NewMockSQSMessageConsumer(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, []byte) error {
+		panic("kaboom")
+	})
+	mockLogger.EXPECT().Error(gomock.Any()).Times(1)
+
+	decorated := WithPanicRecovery(false, 0, mockLogger)(mockConsumer)
+
+	e := decorated.ConsumeMessage(context.Background(), []byte("hello"))
+	assert.ErrorContains(t, e, "kaboom")
+	if _, retryable := e.(RetryableConsumerError); retryable {
+		t.Fatal("expected a terminal error, got RetryableConsumerError")
+	}
+}
+
+func TestWithPanicRecovery_RetryableConvertsPanicToRetryableError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConsumer := NewMockSQSMessageConsumer(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, []byte) error {
+		panic("kaboom")
+	})
+	mockLogger.EXPECT().Error(gomock.Any()).Times(1)
+
+	decorated := WithPanicRecovery(true, 30, mockLogger)(mockConsumer)
+
+	e := decorated.ConsumeMessage(context.Background(), []byte("hello"))
+	retryableErr, ok := e.(RetryableConsumerError)
+	if !ok {
+		t.Fatalf("expected RetryableConsumerError, got %T", e)
+	}
+	assert.Equal(t, retryableErr.VisibilityTimeout, int64(30))
+}
+
+func TestWithPanicRecovery_NoPanicPassesThrough(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConsumer := NewMockSQSMessageConsumer(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("hello")).Return(nil)
+
+	decorated := WithPanicRecovery(true, 30, mockLogger)(mockConsumer)
+	assert.NilError(t, decorated.ConsumeMessage(context.Background(), []byte("hello")))
+}
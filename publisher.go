@@ -0,0 +1,305 @@
+package runsqs
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	logger "github.com/asecurityteam/logevent"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// PublishInput describes a single message to publish to an SQS queue.
+type PublishInput struct {
+	Body              string
+	MessageAttributes map[string]*sqs.MessageAttributeValue
+	// DelaySeconds delays this message's first delivery. Ignored on FIFO queues.
+	DelaySeconds int64
+	// MessageGroupID is required for FIFO queues and ignored on standard queues.
+	MessageGroupID string
+	// MessageDeduplicationID is used by FIFO queues when content-based deduplication
+	// isn't enabled on the queue. Ignored on standard queues.
+	MessageDeduplicationID string
+}
+
+// PublishResult is returned for a successfully published message.
+type PublishResult struct {
+	MessageID string
+	// SequenceNumber is only populated for FIFO queues.
+	SequenceNumber string
+}
+
+// SQSPublisher publishes a single message to an SQS queue.
+type SQSPublisher interface {
+	Publish(ctx context.Context, input PublishInput) (*PublishResult, error)
+}
+
+// PublisherDecorator wraps an SQSPublisher with additional behavior (logging, tracing,
+// metrics, ...), analogous to Decorator for SQSMessageConsumer.
+type PublisherDecorator func(SQSPublisher) SQSPublisher
+
+// PublisherChain is an ordered list of PublisherDecorator. The first decorator in the
+// chain ends up as the outermost layer: Apply wraps from the last decorator to the
+// first, so Chain{WithLogging, WithTracing}.Apply(p) produces WithLogging(WithTracing(p)).
+type PublisherChain []PublisherDecorator
+
+// Apply wraps publisher with every decorator in the chain.
+func (c PublisherChain) Apply(publisher SQSPublisher) SQSPublisher {
+	for i := len(c) - 1; i >= 0; i-- {
+		publisher = c[i](publisher)
+	}
+	return publisher
+}
+
+// DefaultSQSPublisher publishes messages one at a time via SendMessage. It supports
+// message attributes, delayed delivery, and FIFO queues.
+type DefaultSQSPublisher struct {
+	Queue    sqsiface.SQSAPI
+	QueueURL string
+}
+
+// Publish sends a single message immediately via SendMessage.
+func (p *DefaultSQSPublisher) Publish(ctx context.Context, input PublishInput) (*PublishResult, error) {
+	in := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(p.QueueURL),
+		MessageBody:       aws.String(input.Body),
+		MessageAttributes: input.MessageAttributes,
+	}
+	if input.DelaySeconds > 0 {
+		in.DelaySeconds = aws.Int64(input.DelaySeconds)
+	}
+	if input.MessageGroupID != "" {
+		in.MessageGroupId = aws.String(input.MessageGroupID)
+	}
+	if input.MessageDeduplicationID != "" {
+		in.MessageDeduplicationId = aws.String(input.MessageDeduplicationID)
+	}
+	out, e := p.Queue.SendMessageWithContext(ctx, in)
+	if e != nil {
+		return nil, e
+	}
+	return &PublishResult{
+		MessageID:      aws.StringValue(out.MessageId),
+		SequenceNumber: aws.StringValue(out.SequenceNumber),
+	}, nil
+}
+
+// PublishFuture is the pending result of a message published through a
+// BatchingSQSPublisher. The message isn't actually sent until its batch is flushed, so
+// callers await the outcome with Wait.
+type PublishFuture struct {
+	done   chan struct{}
+	result *PublishResult
+	err    error
+}
+
+func newPublishFuture() *PublishFuture {
+	return &PublishFuture{done: make(chan struct{})}
+}
+
+func (f *PublishFuture) complete(result *PublishResult, err error) {
+	f.result = result
+	f.err = err
+	close(f.done)
+}
+
+// Wait blocks until the batch containing this message has been sent, or ctx is done,
+// and returns its result.
+func (f *PublishFuture) Wait(ctx context.Context) (*PublishResult, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pendingPublish struct {
+	id     string
+	input  PublishInput
+	future *PublishFuture
+}
+
+// sqsBatchMaxPayloadBytes is the maximum total message size SQS accepts across all
+// entries in a single SendMessageBatch request.
+const sqsBatchMaxPayloadBytes = 256 * 1024
+
+// BatchingSQSPublisher coalesces individual Publish calls into SendMessageBatch
+// requests, up to the SQS limits of 10 messages / 256KB per batch, flushing whichever of
+// FlushInterval or either limit is reached first. Publish satisfies SQSPublisher (so a
+// BatchingSQSPublisher can be wrapped in a PublisherChain like any other SQSPublisher) by
+// blocking until its message's batch is sent; callers that want to queue many messages
+// without blocking on each one should use PublishAsync instead.
+type BatchingSQSPublisher struct {
+	Queue    sqsiface.SQSAPI
+	QueueURL string
+	Logger   logger.Logger
+	// FlushInterval is the maximum amount of time a message waits in a batch before
+	// being sent. Defaults to 200ms when unset.
+	FlushInterval time.Duration
+
+	mu           sync.Mutex
+	pending      []*pendingPublish
+	pendingBytes int
+	idSeq        uint64
+	flushDone    chan struct{}
+	startOnce    sync.Once
+	flushOnce    sync.Once
+}
+
+var _ SQSPublisher = (*BatchingSQSPublisher)(nil)
+
+// Publish queues a message to be sent as part of the next batch and blocks until that
+// batch is sent, returning its result.
+func (p *BatchingSQSPublisher) Publish(ctx context.Context, input PublishInput) (*PublishResult, error) {
+	future, e := p.PublishAsync(ctx, input)
+	if e != nil {
+		return nil, e
+	}
+	return future.Wait(ctx)
+}
+
+// PublishAsync queues a message to be sent as part of the next batch, returning a
+// PublishFuture the caller can Wait on for the outcome without blocking until the batch
+// actually flushes.
+func (p *BatchingSQSPublisher) PublishAsync(ctx context.Context, input PublishInput) (*PublishFuture, error) {
+	p.startOnce.Do(p.start)
+
+	future := newPublishFuture()
+	size := messageSize(input)
+
+	p.mu.Lock()
+	if len(p.pending) > 0 && p.pendingBytes+size > sqsBatchMaxPayloadBytes {
+		p.mu.Unlock()
+		p.flush()
+		p.mu.Lock()
+	}
+	p.idSeq++
+	id := strconv.FormatUint(p.idSeq, 10)
+	p.pending = append(p.pending, &pendingPublish{id: id, input: input, future: future})
+	p.pendingBytes += size
+	flush := len(p.pending) >= sqsBatchMaxSize || p.pendingBytes >= sqsBatchMaxPayloadBytes
+	p.mu.Unlock()
+	if flush {
+		p.flush()
+	}
+	return future, nil
+}
+
+// messageSize estimates the number of bytes input contributes towards a batch's 256KB
+// limit, counting the body and message attribute names/values the same way SQS does.
+func messageSize(input PublishInput) int {
+	size := len(input.Body)
+	for name, attr := range input.MessageAttributes {
+		size += len(name)
+		if attr.DataType != nil {
+			size += len(*attr.DataType)
+		}
+		if attr.StringValue != nil {
+			size += len(*attr.StringValue)
+		}
+		size += len(attr.BinaryValue)
+	}
+	return size
+}
+
+func (p *BatchingSQSPublisher) start() {
+	if p.FlushInterval <= 0 {
+		p.FlushInterval = defaultAckBatchInterval
+	}
+	p.flushDone = make(chan struct{})
+	go p.run()
+}
+
+func (p *BatchingSQSPublisher) run() {
+	ticker := time.NewTicker(p.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.flush()
+		case <-p.flushDone:
+			p.flush()
+			return
+		}
+	}
+}
+
+// Stop flushes any pending messages and stops the background flush loop. Safe to call
+// even if Publish was never called.
+func (p *BatchingSQSPublisher) Stop() {
+	p.mu.Lock()
+	done := p.flushDone
+	p.mu.Unlock()
+	if done == nil {
+		return
+	}
+	p.flushOnce.Do(func() {
+		close(done)
+	})
+}
+
+func (p *BatchingSQSPublisher) flush() {
+	p.mu.Lock()
+	batch := p.pending
+	p.pending = nil
+	p.pendingBytes = 0
+	p.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	entries := make([]*sqs.SendMessageBatchRequestEntry, 0, len(batch))
+	byID := make(map[string]*pendingPublish, len(batch))
+	for _, pp := range batch {
+		entry := &sqs.SendMessageBatchRequestEntry{
+			Id:                aws.String(pp.id),
+			MessageBody:       aws.String(pp.input.Body),
+			MessageAttributes: pp.input.MessageAttributes,
+		}
+		if pp.input.DelaySeconds > 0 {
+			entry.DelaySeconds = aws.Int64(pp.input.DelaySeconds)
+		}
+		if pp.input.MessageGroupID != "" {
+			entry.MessageGroupId = aws.String(pp.input.MessageGroupID)
+		}
+		if pp.input.MessageDeduplicationID != "" {
+			entry.MessageDeduplicationId = aws.String(pp.input.MessageDeduplicationID)
+		}
+		entries = append(entries, entry)
+		byID[pp.id] = pp
+	}
+
+	out, e := p.Queue.SendMessageBatch(&sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(p.QueueURL),
+		Entries:  entries,
+	})
+	if e != nil {
+		for _, pp := range batch {
+			pp.future.complete(nil, e)
+		}
+		return
+	}
+	for _, ok := range out.Successful {
+		pp, found := byID[aws.StringValue(ok.Id)]
+		if !found {
+			continue
+		}
+		pp.future.complete(&PublishResult{
+			MessageID:      aws.StringValue(ok.MessageId),
+			SequenceNumber: aws.StringValue(ok.SequenceNumber),
+		}, nil)
+	}
+	for _, failed := range out.Failed {
+		pp, found := byID[aws.StringValue(failed.Id)]
+		if !found {
+			continue
+		}
+		p.Logger.Error(failed.String())
+		pp.future.complete(nil, errors.New(aws.StringValue(failed.Message)))
+	}
+}
@@ -0,0 +1,204 @@
+package runsqs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/golang/mock/gomock"
+	"gotest.tools/assert"
+)
+
+func TestDefaultSQSPublisher_Publish(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+
+	publisher := &DefaultSQSPublisher{Queue: mockQueue, QueueURL: queueURL}
+
+	expected := &sqs.SendMessageInput{
+		QueueUrl:               aws.String(queueURL),
+		MessageBody:            aws.String("hello"),
+		MessageGroupId:         aws.String("group-1"),
+		MessageDeduplicationId: aws.String("dedupe-1"),
+		DelaySeconds:           aws.Int64(5),
+	}
+	mockQueue.EXPECT().SendMessageWithContext(gomock.Any(), expected).Return(&sqs.SendMessageOutput{
+		MessageId:      aws.String("msg-1"),
+		SequenceNumber: aws.String("seq-1"),
+	}, nil)
+
+	result, e := publisher.Publish(context.Background(), PublishInput{
+		Body:                   "hello",
+		MessageGroupID:         "group-1",
+		MessageDeduplicationID: "dedupe-1",
+		DelaySeconds:           5,
+	})
+	assert.NilError(t, e)
+	assert.Equal(t, result.MessageID, "msg-1")
+	assert.Equal(t, result.SequenceNumber, "seq-1")
+}
+
+func TestBatchingSQSPublisher_FlushesAtBatchSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+
+	publisher := &BatchingSQSPublisher{Queue: mockQueue, QueueURL: queueURL, FlushInterval: time.Hour}
+	defer publisher.Stop()
+
+	mockQueue.EXPECT().SendMessageBatch(gomock.Any()).DoAndReturn(func(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+		assert.Equal(t, len(input.Entries), sqsBatchMaxSize)
+		successful := make([]*sqs.SendMessageBatchResultEntry, len(input.Entries))
+		for i, entry := range input.Entries {
+			successful[i] = &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String("msg-" + *entry.Id)}
+		}
+		return &sqs.SendMessageBatchOutput{Successful: successful}, nil
+	}).Times(1)
+
+	futures := make([]*PublishFuture, 0, sqsBatchMaxSize)
+	for i := 0; i < sqsBatchMaxSize; i++ {
+		future, e := publisher.PublishAsync(context.Background(), PublishInput{Body: "hello"})
+		assert.NilError(t, e)
+		futures = append(futures, future)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, future := range futures {
+		result, e := future.Wait(ctx)
+		assert.NilError(t, e)
+		assert.Assert(t, result.MessageID != "")
+	}
+}
+
+func TestBatchingSQSPublisher_PartialFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+
+	publisher := &BatchingSQSPublisher{Queue: mockQueue, QueueURL: queueURL, Logger: mockLogger, FlushInterval: 10 * time.Millisecond}
+	defer publisher.Stop()
+
+	mockQueue.EXPECT().SendMessageBatch(gomock.Any()).DoAndReturn(func(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+		return &sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{
+				{Id: input.Entries[0].Id, MessageId: aws.String("msg-1")},
+			},
+			Failed: []*sqs.BatchResultErrorEntry{
+				{Id: input.Entries[1].Id, Code: aws.String("InternalError"), Message: aws.String("boom")},
+			},
+		}, nil
+	}).Times(1)
+	mockLogger.EXPECT().Error(gomock.Any()).Times(1)
+
+	okFuture, e := publisher.PublishAsync(context.Background(), PublishInput{Body: "ok"})
+	assert.NilError(t, e)
+	failFuture, e := publisher.PublishAsync(context.Background(), PublishInput{Body: "fail"})
+	assert.NilError(t, e)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	result, e := okFuture.Wait(ctx)
+	assert.NilError(t, e)
+	assert.Equal(t, result.MessageID, "msg-1")
+
+	_, e = failFuture.Wait(ctx)
+	assert.ErrorContains(t, e, "boom")
+}
+
+func TestPublisherChain(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	counter := 0
+
+	base := &DefaultSQSPublisher{}
+	decorated1 := &DefaultSQSPublisher{QueueURL: "decorated1"}
+	decorated2 := &DefaultSQSPublisher{QueueURL: "decorated2"}
+
+	decorator1 := func(SQSPublisher) SQSPublisher {
+		assert.Equal(t, counter, 0)
+		counter++
+		return decorated1
+	}
+	decorator2 := func(SQSPublisher) SQSPublisher {
+		assert.Equal(t, counter, 1)
+		return decorated2
+	}
+
+	chain := PublisherChain([]PublisherDecorator{decorator2, decorator1})
+	result := chain.Apply(base)
+
+	assert.Equal(t, result, SQSPublisher(decorated2))
+}
+
+func TestBatchingSQSPublisher_FlushesAtPayloadByteLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+
+	publisher := &BatchingSQSPublisher{Queue: mockQueue, QueueURL: queueURL, FlushInterval: 10 * time.Millisecond}
+	defer publisher.Stop()
+
+	// Two bodies that individually fit in a batch but together exceed
+	// sqsBatchMaxPayloadBytes should be split into two SendMessageBatch calls, each with
+	// a single entry, rather than one oversized request. The first is flushed immediately
+	// by the pre-append byte-size guard; the second is left alone in pending and needs the
+	// ticker (not a long FlushInterval) to flush it before Wait's deadline.
+	big := make([]byte, sqsBatchMaxPayloadBytes-1024)
+	for i := range big {
+		big[i] = 'a'
+	}
+
+	mockQueue.EXPECT().SendMessageBatch(gomock.Any()).DoAndReturn(func(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+		assert.Equal(t, len(input.Entries), 1)
+		successful := make([]*sqs.SendMessageBatchResultEntry, len(input.Entries))
+		for i, entry := range input.Entries {
+			successful[i] = &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String("msg-" + *entry.Id)}
+		}
+		return &sqs.SendMessageBatchOutput{Successful: successful}, nil
+	}).Times(2)
+
+	first, e := publisher.PublishAsync(context.Background(), PublishInput{Body: string(big)})
+	assert.NilError(t, e)
+	second, e := publisher.PublishAsync(context.Background(), PublishInput{Body: string(big)})
+	assert.NilError(t, e)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, e := first.Wait(ctx)
+	assert.NilError(t, e)
+	assert.Assert(t, result.MessageID != "")
+	result, e = second.Wait(ctx)
+	assert.NilError(t, e)
+	assert.Assert(t, result.MessageID != "")
+}
+
+func TestBatchingSQSPublisher_PublishSatisfiesSQSPublisher(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+
+	publisher := &BatchingSQSPublisher{Queue: mockQueue, QueueURL: queueURL, FlushInterval: 10 * time.Millisecond}
+	defer publisher.Stop()
+
+	mockQueue.EXPECT().SendMessageBatch(gomock.Any()).DoAndReturn(func(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+		return &sqs.SendMessageBatchOutput{
+			Successful: []*sqs.SendMessageBatchResultEntry{
+				{Id: input.Entries[0].Id, MessageId: aws.String("msg-1")},
+			},
+		}, nil
+	}).Times(1)
+
+	// assigning to the interface type proves Publish's signature matches SQSPublisher,
+	// so BatchingSQSPublisher can be wrapped by a PublisherChain like DefaultSQSPublisher.
+	var sqsPublisher SQSPublisher = publisher
+	result, e := sqsPublisher.Publish(context.Background(), PublishInput{Body: "hello"})
+	assert.NilError(t, e)
+	assert.Equal(t, result.MessageID, "msg-1")
+}
@@ -3,7 +3,9 @@ package runsqs
 import (
 	"context"
 	"math"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	logger "github.com/asecurityteam/logevent"
@@ -13,7 +15,13 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
-var mutex = &sync.Mutex{}
+// sqsBatchMaxSize is the maximum number of entries SQS accepts in a single
+// DeleteMessageBatch/ChangeMessageVisibilityBatch/ReceiveMessage(MaxNumberOfMessages) call.
+const sqsBatchMaxSize = 10
+
+// defaultAckBatchInterval is the flush window used for AckBatchInterval when
+// UseBatchDelete is enabled and no interval is configured.
+const defaultAckBatchInterval = 200 * time.Millisecond
 
 // DefaultSQSQueueConsumer is a naive implementation of an SQSConsumer.
 // This implementation has no support for retries on nonpermanent failures;
@@ -24,30 +32,55 @@ type DefaultSQSQueueConsumer struct {
 	Queue           sqsiface.SQSAPI
 	Logger          logger.Logger
 	QueueURL        string
-	deactivate      chan bool
 	MessageConsumer SQSMessageConsumer
+
+	// Backoff controls how long to wait after a failed receive, and between retries of
+	// a retryable/throttled ack. Defaults to a constant 1 second delay when unset.
+	Backoff BackoffStrategy
+	// MaxRetries caps the number of attempts (the first one included) made at a single
+	// ack before it is abandoned and logged. Zero means retry indefinitely, matching
+	// prior behavior.
+	MaxRetries int
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 // StartConsuming starts consuming from the configured SQS queue
 func (m *DefaultSQSQueueConsumer) StartConsuming(ctx context.Context) error {
 
-	mutex.Lock()
-	m.deactivate = make(chan bool)
-	mutex.Unlock()
+	// recvCtx is cancelled as soon as either the caller's ctx is done or StopConsuming
+	// calls m.cancel, so a blocking long-poll ReceiveMessage returns promptly instead of
+	// riding out its up-to-15s WaitTimeSeconds. Storing cancel on the struct (guarded by
+	// m.mu, unshared with any other consumer) is what lets multiple DefaultSQSQueueConsumer
+	// instances start/stop independently of one another.
+	recvCtx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	m.cancel = cancel
+	m.mu.Unlock()
+	defer cancel()
+
+	m.wg.Add(1)
+	defer m.wg.Done()
+
+	backoff := m.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
 
-	var done = ctx.Done()
+	var recvAttempt int
 	for {
 		select {
-		case <-done:
-			return nil
-		case <-m.deactivate:
+		case <-recvCtx.Done():
 			return nil
 		default:
 		}
-		var result, e = m.Queue.ReceiveMessage(&sqs.ReceiveMessageInput{
+		var result, e = m.Queue.ReceiveMessageWithContext(recvCtx, &sqs.ReceiveMessageInput{
 			QueueUrl: aws.String(m.QueueURL),
 			AttributeNames: aws.StringSlice([]string{
 				"SentTimestamp",
+				"ApproximateReceiveCount",
 			}),
 			MessageAttributeNames: aws.StringSlice([]string{
 				"All",
@@ -55,14 +88,23 @@ func (m *DefaultSQSQueueConsumer) StartConsuming(ctx context.Context) error {
 			WaitTimeSeconds: aws.Int64(int64(math.Ceil((15 * time.Second).Seconds()))),
 		})
 		if e != nil {
+			if recvCtx.Err() != nil {
+				return nil
+			}
 			if !(request.IsErrorRetryable(e) || request.IsErrorThrottle(e)) {
 				m.Logger.Error(e.Error())
 			}
-			time.Sleep(1 * time.Second)
+			recvAttempt++
+			time.Sleep(backoff.NextDelay(recvAttempt, e))
 			continue
 		}
+		recvAttempt = 0
 		for _, message := range result.Messages {
-			_ = m.GetSQSMessageConsumer().ConsumeMessage(ctx, []byte(*message.Body))
+			consumeCtx := withMessageContext(ctx, MessageContext{
+				Attributes:        message.Attributes,
+				MessageAttributes: message.MessageAttributes,
+			})
+			_ = m.GetSQSMessageConsumer().ConsumeMessage(consumeCtx, []byte(*message.Body))
 			m.ackMessage(ctx, func() error {
 				var _, e = m.Queue.DeleteMessage(&sqs.DeleteMessageInput{
 					QueueUrl:      aws.String(m.QueueURL),
@@ -75,13 +117,17 @@ func (m *DefaultSQSQueueConsumer) StartConsuming(ctx context.Context) error {
 	}
 }
 
-// StopConsuming stops this DefaultSQSQueueConsumer consuming from the SQS queue
+// StopConsuming stops this DefaultSQSQueueConsumer consuming from the SQS queue. It
+// blocks until StartConsuming has returned so that callers can rely on no further
+// message processing happening once this returns.
 func (m *DefaultSQSQueueConsumer) StopConsuming(ctx context.Context) error {
-	mutex.Lock()
-	if m.deactivate != nil {
-		close(m.deactivate)
+	m.mu.Lock()
+	cancel := m.cancel
+	m.mu.Unlock()
+	if cancel != nil {
+		cancel()
 	}
-	mutex.Unlock()
+	m.wg.Wait()
 	return nil
 }
 
@@ -92,14 +138,22 @@ func (m *DefaultSQSQueueConsumer) GetSQSMessageConsumer() SQSMessageConsumer {
 }
 
 func (m *DefaultSQSQueueConsumer) ackMessage(ctx context.Context, ack func() error) {
-	for {
+	backoff := m.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+	for attempt := 1; ; attempt++ {
 		e := ack()
 		if e != nil {
 			if !(request.IsErrorRetryable(e) || request.IsErrorThrottle(e)) {
 				m.Logger.Error(e.Error())
 				break
 			}
-			time.Sleep(1 * time.Second)
+			if m.MaxRetries > 0 && attempt >= m.MaxRetries {
+				m.Logger.Error(e.Error())
+				break
+			}
+			time.Sleep(backoff.NextDelay(attempt, e))
 			continue
 		}
 		break
@@ -114,85 +168,247 @@ type SmartSQSConsumer struct {
 	Queue           sqsiface.SQSAPI
 	Logger          logger.Logger
 	QueueURL        string
-	deactivate      chan bool
 	MessageConsumer SQSMessageConsumer
 	NumWorkers      uint64
 	MessagePoolSize uint64
+
+	// UseBatchDelete enables batching of message deletions (via DeleteMessageBatch) and
+	// retryable acks (via ChangeMessageVisibilityBatch) instead of issuing one SQS API
+	// call per message. Receipt handles are grouped until AckBatchInterval elapses or
+	// sqsBatchMaxSize handles accumulate, whichever happens first.
+	UseBatchDelete bool
+	// AckBatchInterval is the maximum amount of time a receipt handle waits in a batch
+	// before being flushed. Defaults to 200ms when UseBatchDelete is true and this is unset.
+	AckBatchInterval time.Duration
+	// MaxInFlight bounds the number of messages that have been received but not yet
+	// finished processing. When non-zero, StartConsuming stops calling ReceiveMessage
+	// once this many messages are in flight, so SQS is never asked for more messages
+	// than the worker pool can actually handle. A reasonable value is NumWorkers
+	// multiplied by the number of messages each worker can hold in flight.
+	MaxInFlight uint64
+
+	// Backoff controls how long to wait after a failed receive, and between retries of
+	// a retryable/throttled ack. Defaults to a constant 1 second delay when unset.
+	Backoff BackoffStrategy
+	// MaxRetries caps the number of attempts (the first one included) made at a single
+	// ack before it is abandoned and logged. Zero means retry indefinitely, matching
+	// prior behavior.
+	MaxRetries int
+
+	// VisibilityHeartbeat, when non-zero, spawns a goroutine per in-flight message that
+	// calls ChangeMessageVisibility every VisibilityHeartbeat, extending the message's
+	// visibility timeout by VisibilityHeartbeat*2, for as long as its handler is still
+	// running. This lets a slow handler keep a message invisible to other consumers
+	// without having to guess a single upfront visibility timeout.
+	VisibilityHeartbeat time.Duration
+	// MaxVisibility bounds the total amount of time VisibilityHeartbeat will keep
+	// extending a single message's visibility. Zero means extend indefinitely for as
+	// long as the handler runs.
+	MaxVisibility time.Duration
+
+	inFlight          uint64
+	batchMu           sync.Mutex
+	batchIDSeq        uint64
+	pendingDeletes    []*sqs.DeleteMessageBatchRequestEntry
+	pendingVisibility []*sqs.ChangeMessageVisibilityBatchRequestEntry
+	flushOnce         sync.Once
+	flushDone         chan struct{}
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	stopped chan struct{}
 }
 
 // StartConsuming starts consuming from the configured SQS queue
 func (m *SmartSQSConsumer) StartConsuming(ctx context.Context) error {
 
-	mutex.Lock()
-	m.deactivate = make(chan bool)
+	// recvCtx is cancelled as soon as either the caller's ctx is done or StopConsuming
+	// calls m.cancel, so a blocking long-poll ReceiveMessage returns promptly instead of
+	// riding out its up-to-15s WaitTimeSeconds. Storing cancel on the struct (guarded by
+	// m.mu, unshared with any other consumer) is what lets multiple SmartSQSConsumer
+	// instances start/stop independently of one another.
+	recvCtx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	m.cancel = cancel
+	m.stopped = make(chan struct{})
 	// messagePool represents a queue of messages that are waiting to be consumed
 	messagePool := make(chan *sqs.Message, m.MessagePoolSize)
 
+	if m.UseBatchDelete {
+		if m.AckBatchInterval <= 0 {
+			m.AckBatchInterval = defaultAckBatchInterval
+		}
+		m.flushDone = make(chan struct{})
+		go m.runBatchFlusher()
+	}
+
 	// initialize all workers, pass in the pool of messages for each worker
 	// to consume from
 	for i := uint64(0); i < m.NumWorkers; i++ {
-		go m.worker(ctx, messagePool)
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.worker(ctx, messagePool)
+		}()
 	}
-	mutex.Unlock()
-	var done = ctx.Done()
+	m.mu.Unlock()
+
+	defer cancel()
+	defer close(m.stopped)
+
+	backoff := m.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+
+	var recvAttempt int
 	for {
 		select {
-		case <-done:
-			// these close statements will cause all workers to eventually terminate
-			close(messagePool)
-			return nil
-		case <-m.deactivate:
-			close(messagePool)
+		case <-recvCtx.Done():
+			m.shutdown(messagePool)
 			return nil
 		default:
 		}
-		var result, e = m.Queue.ReceiveMessage(&sqs.ReceiveMessageInput{
+		// MaxInFlight bounds how many messages we allow outstanding at once; when the
+		// pool is saturated we skip calling ReceiveMessage rather than pull in more
+		// messages than the workers can actually process.
+		if m.MaxInFlight > 0 && atomic.LoadUint64(&m.inFlight) >= m.MaxInFlight {
+			time.Sleep(time.Duration(1) * time.Millisecond)
+			continue
+		}
+		// never ask for more messages than the remaining MaxInFlight room
+		maxMessages := uint64(sqsBatchMaxSize)
+		if m.MaxInFlight > 0 {
+			if remaining := m.MaxInFlight - atomic.LoadUint64(&m.inFlight); remaining < maxMessages {
+				maxMessages = remaining
+			}
+		}
+		var result, e = m.Queue.ReceiveMessageWithContext(recvCtx, &sqs.ReceiveMessageInput{
 			QueueUrl: aws.String(m.QueueURL),
 			AttributeNames: aws.StringSlice([]string{
 				"SentTimestamp",
+				"ApproximateReceiveCount",
 			}),
 			MessageAttributeNames: aws.StringSlice([]string{
 				"All",
 			}),
-			WaitTimeSeconds: aws.Int64(int64(math.Ceil((15 * time.Second).Seconds()))),
+			MaxNumberOfMessages: aws.Int64(int64(maxMessages)),
+			WaitTimeSeconds:     aws.Int64(int64(math.Ceil((15 * time.Second).Seconds()))),
 		})
 		if e != nil {
+			if recvCtx.Err() != nil {
+				m.shutdown(messagePool)
+				return nil
+			}
 			if !(request.IsErrorRetryable(e) || request.IsErrorThrottle(e)) {
 				m.Logger.Error(e.Error())
 			}
-			time.Sleep(1 * time.Second)
+			recvAttempt++
+			time.Sleep(backoff.NextDelay(recvAttempt, e))
 			continue
 		}
+		recvAttempt = 0
 		// loop through every message, and queue each message onto messagePool.
 		// Because messagePool is a fixed buffered channel, there is potential for this to block.
 		// It's important to set MessagePoolSize to a high enough size to account for high sqs throughput
 		for _, message := range result.Messages {
+			atomic.AddUint64(&m.inFlight, 1)
 			messagePool <- message
 		}
 		time.Sleep(time.Duration(1) * time.Millisecond)
 	}
 }
 
+// shutdown closes messagePool (letting any messages already buffered in it drain to
+// workers) and waits for every worker to finish its in-flight message before returning,
+// so StopConsuming can safely block until no more processing is happening.
+func (m *SmartSQSConsumer) shutdown(messagePool chan *sqs.Message) {
+	close(messagePool)
+	m.wg.Wait()
+	m.stopBatchFlusher()
+}
+
+// visibilityTimeoutSeconds converts a heartbeat extension duration into the whole
+// seconds ChangeMessageVisibility expects, rounding up and enforcing a 1-second floor.
+// Without the floor, a sub-second VisibilityHeartbeat (e.g. extend(heartbeat*2) with a
+// heartbeat under 500ms) truncates to 0 seconds, which makes the message immediately
+// visible to other consumers again instead of extending it.
+func visibilityTimeoutSeconds(d time.Duration) int64 {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// consumeMessage runs the configured MessageConsumer against a single message, starting
+// a visibilityExtender heartbeat for the duration of the call when VisibilityHeartbeat
+// is configured.
+func (m *SmartSQSConsumer) consumeMessage(ctx context.Context, message *sqs.Message) error {
+	extend := func(timeout time.Duration) error {
+		var _, e = m.Queue.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(m.QueueURL),
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: aws.Int64(visibilityTimeoutSeconds(timeout)),
+		})
+		return e
+	}
+
+	consumeCtx := withMessageContext(ctx, MessageContext{
+		Attributes:        message.Attributes,
+		MessageAttributes: message.MessageAttributes,
+	})
+	var extender *visibilityExtender
+	if m.VisibilityHeartbeat > 0 {
+		extender = newVisibilityExtender(m.VisibilityHeartbeat, m.MaxVisibility, extend, m.Logger)
+		consumeCtx = withExtendTimeout(consumeCtx, extend)
+		defer extender.Stop()
+	}
+
+	if withExtend, ok := m.GetSQSMessageConsumer().(SQSMessageConsumerWithExtend); ok {
+		return withExtend.ConsumeMessageWithExtend(consumeCtx, []byte(*message.Body), extend)
+	}
+	return m.GetSQSMessageConsumer().ConsumeMessage(consumeCtx, []byte(*message.Body))
+}
+
 // worker function represents a single "message worker." worker will infinitely process messages until
 // messages is closed. worker is responsible for handling deletion of messages, or handling
 // messages that have retryable error.
 func (m *SmartSQSConsumer) worker(ctx context.Context, messages <-chan *sqs.Message) {
 	for message := range messages {
-		err := m.GetSQSMessageConsumer().ConsumeMessage(ctx, []byte(*message.Body))
-		if err != nil {
-			switch err.(type) {
-			case RetryableConsumerError:
-				retryableErr := err.(RetryableConsumerError)
+		switch err := m.consumeMessage(ctx, message).(type) {
+		case nil:
+			if m.UseBatchDelete {
+				m.enqueueDelete(message.ReceiptHandle)
+			} else {
+				m.ackMessage(ctx, func() error {
+					var _, e = m.Queue.DeleteMessage(&sqs.DeleteMessageInput{
+						QueueUrl:      aws.String(m.QueueURL),
+						ReceiptHandle: message.ReceiptHandle,
+					})
+					return e
+				})
+			}
+
+		case RetryableConsumerError:
+			if m.UseBatchDelete {
+				m.enqueueVisibility(message.ReceiptHandle, err.VisibilityTimeout)
+			} else {
 				m.ackMessage(ctx, func() error {
 					var _, e = m.Queue.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
 						QueueUrl:          aws.String(m.QueueURL),
 						ReceiptHandle:     message.ReceiptHandle,
-						VisibilityTimeout: &retryableErr.VisibilityTimeout,
+						VisibilityTimeout: &err.VisibilityTimeout,
 					})
 					return e
 				})
+			}
 
-			default:
+		default:
+			if m.UseBatchDelete {
+				m.enqueueDelete(message.ReceiptHandle)
+			} else {
 				m.ackMessage(ctx, func() error {
 					var _, e = m.Queue.DeleteMessage(&sqs.DeleteMessageInput{
 						QueueUrl:      aws.String(m.QueueURL),
@@ -200,16 +416,115 @@ func (m *SmartSQSConsumer) worker(ctx context.Context, messages <-chan *sqs.Mess
 					})
 					return e
 				})
-
 			}
 		}
-		m.ackMessage(ctx, func() error {
-			var _, e = m.Queue.DeleteMessage(&sqs.DeleteMessageInput{
-				QueueUrl:      aws.String(m.QueueURL),
-				ReceiptHandle: message.ReceiptHandle,
-			})
-			return e
-		})
+		atomic.AddUint64(&m.inFlight, ^uint64(0))
+	}
+}
+
+// enqueueDelete adds a receipt handle to the pending DeleteMessageBatch request, flushing
+// immediately if the batch has reached sqsBatchMaxSize entries.
+func (m *SmartSQSConsumer) enqueueDelete(receiptHandle *string) {
+	m.batchMu.Lock()
+	m.pendingDeletes = append(m.pendingDeletes, &sqs.DeleteMessageBatchRequestEntry{
+		Id:            aws.String(m.nextBatchID()),
+		ReceiptHandle: receiptHandle,
+	})
+	flush := len(m.pendingDeletes) >= sqsBatchMaxSize
+	m.batchMu.Unlock()
+	if flush {
+		m.flushDeletes()
+	}
+}
+
+// enqueueVisibility adds a receipt handle to the pending ChangeMessageVisibilityBatch
+// request, flushing immediately if the batch has reached sqsBatchMaxSize entries.
+func (m *SmartSQSConsumer) enqueueVisibility(receiptHandle *string, visibilityTimeout int64) {
+	m.batchMu.Lock()
+	m.pendingVisibility = append(m.pendingVisibility, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+		Id:                aws.String(m.nextBatchID()),
+		ReceiptHandle:     receiptHandle,
+		VisibilityTimeout: aws.Int64(visibilityTimeout),
+	})
+	flush := len(m.pendingVisibility) >= sqsBatchMaxSize
+	m.batchMu.Unlock()
+	if flush {
+		m.flushVisibility()
+	}
+}
+
+// nextBatchID returns a unique Id for use in a batch request entry. Callers must hold batchMu.
+func (m *SmartSQSConsumer) nextBatchID() string {
+	m.batchIDSeq++
+	return strconv.FormatUint(m.batchIDSeq, 10)
+}
+
+// runBatchFlusher periodically flushes any pending batched deletes/visibility changes
+// until stopBatchFlusher closes m.flushDone.
+func (m *SmartSQSConsumer) runBatchFlusher() {
+	ticker := time.NewTicker(m.AckBatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.flushDeletes()
+			m.flushVisibility()
+		case <-m.flushDone:
+			m.flushDeletes()
+			m.flushVisibility()
+			return
+		}
+	}
+}
+
+func (m *SmartSQSConsumer) stopBatchFlusher() {
+	if !m.UseBatchDelete {
+		return
+	}
+	m.flushOnce.Do(func() {
+		close(m.flushDone)
+	})
+}
+
+func (m *SmartSQSConsumer) flushDeletes() {
+	m.batchMu.Lock()
+	entries := m.pendingDeletes
+	m.pendingDeletes = nil
+	m.batchMu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	result, e := m.Queue.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(m.QueueURL),
+		Entries:  entries,
+	})
+	if e != nil {
+		m.Logger.Error(e.Error())
+		return
+	}
+	for _, failed := range result.Failed {
+		m.Logger.Error(failed.String())
+	}
+}
+
+func (m *SmartSQSConsumer) flushVisibility() {
+	m.batchMu.Lock()
+	entries := m.pendingVisibility
+	m.pendingVisibility = nil
+	m.batchMu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	result, e := m.Queue.ChangeMessageVisibilityBatch(&sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: aws.String(m.QueueURL),
+		Entries:  entries,
+	})
+	if e != nil {
+		m.Logger.Error(e.Error())
+		return
+	}
+	for _, failed := range result.Failed {
+		m.Logger.Error(failed.String())
 	}
 }
 
@@ -220,26 +535,41 @@ func (m *SmartSQSConsumer) GetSQSMessageConsumer() SQSMessageConsumer {
 }
 
 func (m *SmartSQSConsumer) ackMessage(ctx context.Context, ack func() error) {
-	for {
+	backoff := m.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+	for attempt := 1; ; attempt++ {
 		e := ack()
 		if e != nil {
 			if !(request.IsErrorRetryable(e) || request.IsErrorThrottle(e)) {
 				m.Logger.Error(e.Error())
 				break
 			}
-			time.Sleep(1 * time.Second)
+			if m.MaxRetries > 0 && attempt >= m.MaxRetries {
+				m.Logger.Error(e.Error())
+				break
+			}
+			time.Sleep(backoff.NextDelay(attempt, e))
 			continue
 		}
 		break
 	}
 }
 
-// StopConsuming stops this DefaultSQSQueueConsumer consuming from the SQS queue
+// StopConsuming stops this SmartSQSConsumer consuming from the SQS queue. It blocks
+// until every worker has finished its in-flight message so callers can rely on no
+// further message processing happening once this returns.
 func (m *SmartSQSConsumer) StopConsuming(ctx context.Context) error {
-	mutex.Lock()
-	if m.deactivate != nil {
-		close(m.deactivate)
+	m.mu.Lock()
+	cancel := m.cancel
+	stopped := m.stopped
+	m.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if stopped != nil {
+		<-stopped
 	}
-	mutex.Unlock()
 	return nil
-}
\ No newline at end of file
+}
@@ -2,15 +2,20 @@ package runsqs
 
 import (
 	"context"
+	"errors"
 	"math"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	aws "github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	gomock "github.com/golang/mock/gomock"
+	"gotest.tools/assert"
 )
 
 var queueURL = "http://awssomething.com"
@@ -19,6 +24,7 @@ var sqsInput = &sqs.ReceiveMessageInput{
 	QueueUrl: aws.String(queueURL),
 	AttributeNames: aws.StringSlice([]string{
 		"SentTimestamp",
+		"ApproximateReceiveCount",
 	}),
 	MessageAttributeNames: aws.StringSlice([]string{
 		"All",
@@ -26,6 +32,19 @@ var sqsInput = &sqs.ReceiveMessageInput{
 	WaitTimeSeconds: aws.Int64(int64(math.Ceil((15 * time.Second).Seconds()))),
 }
 
+var smartSQSInput = &sqs.ReceiveMessageInput{
+	QueueUrl: aws.String(queueURL),
+	AttributeNames: aws.StringSlice([]string{
+		"SentTimestamp",
+		"ApproximateReceiveCount",
+	}),
+	MessageAttributeNames: aws.StringSlice([]string{
+		"All",
+	}),
+	MaxNumberOfMessages: aws.Int64(10),
+	WaitTimeSeconds:     aws.Int64(int64(math.Ceil((15 * time.Second).Seconds()))),
+}
+
 var sqsEmptyMessageOutput = &sqs.ReceiveMessageOutput{
 	Messages: []*sqs.Message{},
 }
@@ -65,7 +84,7 @@ func TestDefaultSQSQueueConsumer_GoldenPath(t *testing.T) {
 	}
 
 	// the following mocks test for exactly 5 successful message consumptions, no more no less
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).Return(receiveMessageOutput, nil).Times(5)
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), sqsInput).Return(receiveMessageOutput, nil).Times(5)
 	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).Return(nil).Times(5)
 	mockQueue.EXPECT().DeleteMessage(gomock.Any()).DoAndReturn(func(interface{}) (*sqs.DeleteMessageOutput, error) {
 		testBlocker.Done()
@@ -73,7 +92,7 @@ func TestDefaultSQSQueueConsumer_GoldenPath(t *testing.T) {
 	}).Times(5)
 
 	// infinitely ping empty sqs
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), sqsInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
 
 	testBlocker.Add(5)
 	go consumer.StartConsuming(context.Background())
@@ -102,13 +121,13 @@ func TestDefaultSQSQueueConsumer_ReceivingMessageFailure(t *testing.T) {
 	}
 
 	// 1 retryables, 1 error log
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).Return(sqsEmptyMessageOutput, awserr.New("RequestThrottled", "test", nil))
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), sqsInput).Return(sqsEmptyMessageOutput, awserr.New("RequestThrottled", "test", nil))
 	mockLogger.EXPECT().Error(gomock.Any()).Times(1)
 	// non retryable
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).Return(sqsEmptyMessageOutput, awserr.New("RequestCanceled", "test", nil))
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), sqsInput).Return(sqsEmptyMessageOutput, awserr.New("RequestCanceled", "test", nil))
 
 	// infinitely ping empty sqs
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).DoAndReturn(func(interface{}) (interface{}, error) {
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), sqsInput).DoAndReturn(func(ctx context.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
 		defer testBlocker.Done()
 		return sqsEmptyMessageOutput, nil
 	}).AnyTimes()
@@ -153,7 +172,7 @@ func TestSmartSQSConsumer_GoldenPath(t *testing.T) {
 		Messages: messages,
 	}
 	// the following mocks test for exactly 5 successful message consumptions, no more no less
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).Return(receiveMessageOutput, nil).Times(5)
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(receiveMessageOutput, nil).Times(5)
 	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).Return(nil).Times(5000)
 	mockQueue.EXPECT().DeleteMessage(gomock.Any()).DoAndReturn(func(interface{}) (*sqs.DeleteMessageOutput, error) {
 		testBlocker.Done()
@@ -161,7 +180,7 @@ func TestSmartSQSConsumer_GoldenPath(t *testing.T) {
 	}).Times(5000)
 
 	// infinitely ping empty sqs
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
 
 	testBlocker.Add(5000)
 	go consumer.StartConsuming(context.Background())
@@ -192,13 +211,13 @@ func TestSmartSQSConsumer_ReceivingMessageFailure(t *testing.T) {
 	}
 
 	// 1 retryables, 1 error log
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).Return(sqsEmptyMessageOutput, awserr.New("RequestThrottled", "test", nil))
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(sqsEmptyMessageOutput, awserr.New("RequestThrottled", "test", nil))
 	mockLogger.EXPECT().Error(gomock.Any()).Times(1)
 	// non retryable
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).Return(sqsEmptyMessageOutput, awserr.New("RequestCanceled", "test", nil))
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(sqsEmptyMessageOutput, awserr.New("RequestCanceled", "test", nil))
 
 	// infinitely ping empty sqs
-	mockQueue.EXPECT().ReceiveMessage(sqsInput).DoAndReturn(func(interface{}) (interface{}, error) {
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).DoAndReturn(func(ctx context.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
 		defer testBlocker.Done()
 		return sqsEmptyMessageOutput, nil
 	}).AnyTimes()
@@ -207,4 +226,426 @@ func TestSmartSQSConsumer_ReceivingMessageFailure(t *testing.T) {
 	testBlocker.Wait()
 	consumer.StopConsuming(context.Background())
 
-}
\ No newline at end of file
+}
+
+// TestSmartSQSConsumer_BatchDelete_GoldenPath tests that, with UseBatchDelete enabled,
+// receipt handles are grouped and deleted via a single DeleteMessageBatch call once
+// sqsBatchMaxSize handles have accumulated.
+func TestSmartSQSConsumer_BatchDelete_GoldenPath(t *testing.T) {
+	// mocks
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+	mockMessageConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	// testBlocker is used to make this test deterministic(avoid timeouts)
+	var testBlocker sync.WaitGroup
+	var consumer = &SmartSQSConsumer{
+		Logger:           mockLogger,
+		QueueURL:         queueURL,
+		Queue:            mockQueue,
+		MessageConsumer:  mockMessageConsumer,
+		NumWorkers:       1,
+		MessagePoolSize:  20,
+		UseBatchDelete:   true,
+		AckBatchInterval: 10 * time.Millisecond,
+	}
+
+	messages := []*sqs.Message{}
+	for i := 0; i < 10; i++ {
+		messages = append(messages, defaultSQSMessage)
+	}
+
+	receiveMessageOutput := &sqs.ReceiveMessageOutput{
+		Messages: messages,
+	}
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(receiveMessageOutput, nil).Times(1)
+	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).Return(nil).Times(10)
+	mockQueue.EXPECT().DeleteMessageBatch(gomock.Any()).DoAndReturn(func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		testBlocker.Done()
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}).Times(1)
+
+	// infinitely ping empty sqs
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
+
+	testBlocker.Add(1)
+	go consumer.StartConsuming(context.Background())
+	testBlocker.Wait()
+	consumer.StopConsuming(context.Background())
+}
+
+// TestSmartSQSConsumer_BatchDelete_PartialFailure tests that entries returned in a
+// DeleteMessageBatchOutput's Failed list are logged without failing the other entries
+// in the same batch.
+func TestSmartSQSConsumer_BatchDelete_PartialFailure(t *testing.T) {
+	// mocks
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+	mockMessageConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	// testBlocker is used to make this test deterministic(avoid timeouts)
+	var testBlocker sync.WaitGroup
+	var consumer = &SmartSQSConsumer{
+		Logger:           mockLogger,
+		QueueURL:         queueURL,
+		Queue:            mockQueue,
+		MessageConsumer:  mockMessageConsumer,
+		NumWorkers:       1,
+		MessagePoolSize:  20,
+		UseBatchDelete:   true,
+		AckBatchInterval: 10 * time.Millisecond,
+	}
+
+	receiveMessageOutput := &sqs.ReceiveMessageOutput{
+		Messages: []*sqs.Message{defaultSQSMessage, defaultSQSMessage},
+	}
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(receiveMessageOutput, nil).Times(1)
+	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).Return(nil).Times(2)
+	// the flusher's ticker fires on AckBatchInterval, so the 2 pending entries are
+	// delivered in a single batch rather than triggering the sqsBatchMaxSize threshold
+	mockQueue.EXPECT().DeleteMessageBatch(gomock.Any()).DoAndReturn(func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		return &sqs.DeleteMessageBatchOutput{
+			Failed: []*sqs.BatchResultErrorEntry{
+				{Id: input.Entries[0].Id, Code: aws.String("ReceiptHandleIsInvalid"), Message: aws.String("invalid receipt handle")},
+			},
+		}, nil
+	}).Times(1)
+	mockLogger.EXPECT().Error(gomock.Any()).DoAndReturn(func(interface{}) error {
+		testBlocker.Done()
+		return nil
+	}).Times(1)
+
+	// infinitely ping empty sqs
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
+
+	testBlocker.Add(1)
+	go consumer.StartConsuming(context.Background())
+	testBlocker.Wait()
+	consumer.StopConsuming(context.Background())
+}
+
+// TestSmartSQSConsumer_StopsGracefullyWhenCancelled tests that cancelling the context
+// passed to StartConsuming causes a blocked long-poll ReceiveMessageWithContext to
+// return promptly and all worker goroutines to exit, leaving no leaked goroutines
+// behind once StopConsuming returns.
+func TestSmartSQSConsumer_StopsGracefullyWhenCancelled(t *testing.T) {
+	// mocks
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+	mockMessageConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	var consumer = &SmartSQSConsumer{
+		Logger:          mockLogger,
+		QueueURL:        queueURL,
+		Queue:           mockQueue,
+		MessageConsumer: mockMessageConsumer,
+		NumWorkers:      10,
+		MessagePoolSize: 100,
+	}
+
+	baseline := runtime.NumGoroutine()
+
+	// ReceiveMessageWithContext blocks until the context passed to it is cancelled,
+	// simulating a long-poll in flight when shutdown is requested.
+	receiving := make(chan struct{})
+	var once sync.Once
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).DoAndReturn(
+		func(ctx context.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+			once.Do(func() { close(receiving) })
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go consumer.StartConsuming(ctx)
+	<-receiving
+	cancel()
+	consumer.StopConsuming(context.Background())
+
+	// allow goroutines that have already been signalled a moment to actually unwind
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline+2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > baseline+2 {
+		t.Fatalf("expected goroutine count to return near baseline %d, got %d", baseline, got)
+	}
+}
+
+// TestSmartSQSConsumer_AckMessage_MaxRetriesGivesUp tests that ackMessage gives up and
+// logs once MaxRetries attempts of a retryable error have been made, rather than
+// retrying forever.
+func TestSmartSQSConsumer_AckMessage_MaxRetriesGivesUp(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := NewMockLogger(ctrl)
+
+	var consumer = &SmartSQSConsumer{
+		Logger:     mockLogger,
+		QueueURL:   queueURL,
+		MaxRetries: 3,
+		Backoff:    ConstantBackoff{Delay: time.Millisecond},
+	}
+
+	attempts := 0
+	mockLogger.EXPECT().Error(gomock.Any()).Times(1)
+
+	consumer.ackMessage(context.Background(), func() error {
+		attempts++
+		return awserr.New("RequestThrottled", "test", nil)
+	})
+
+	if attempts != 3 {
+		t.Fatalf("expected exactly 3 attempts, got %d", attempts)
+	}
+}
+
+// TestSmartSQSConsumer_VisibilityHeartbeat tests that, while a handler is still
+// running, VisibilityHeartbeat causes the consumer to periodically extend the
+// message's visibility timeout via ChangeMessageVisibility.
+func TestSmartSQSConsumer_VisibilityHeartbeat(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+	mockMessageConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	var testBlocker sync.WaitGroup
+	var extensions uint64
+	var consumer = &SmartSQSConsumer{
+		Logger:              mockLogger,
+		QueueURL:            queueURL,
+		Queue:               mockQueue,
+		MessageConsumer:     mockMessageConsumer,
+		NumWorkers:          1,
+		MessagePoolSize:     5,
+		VisibilityHeartbeat: 10 * time.Millisecond,
+	}
+
+	receiveMessageOutput := &sqs.ReceiveMessageOutput{
+		Messages: []*sqs.Message{defaultSQSMessage},
+	}
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(receiveMessageOutput, nil).Times(1)
+	mockQueue.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
+
+	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).DoAndReturn(
+		func(ctx context.Context, body []byte) error {
+			time.Sleep(35 * time.Millisecond)
+			return nil
+		}).Times(1)
+	mockQueue.EXPECT().ChangeMessageVisibility(gomock.Any()).DoAndReturn(
+		func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+			if atomic.AddUint64(&extensions, 1) == 2 {
+				testBlocker.Done()
+			}
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		}).MinTimes(2)
+	mockQueue.EXPECT().DeleteMessage(gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil).Times(1)
+
+	testBlocker.Add(1)
+	go consumer.StartConsuming(context.Background())
+	testBlocker.Wait()
+	consumer.StopConsuming(context.Background())
+}
+
+// TestSmartSQSConsumer_TwoInstancesRunIndependently tests that two SmartSQSConsumer
+// instances pointed at different queues can start, drain, and stop concurrently without
+// interfering with one another, now that they no longer share a package-level mutex.
+func TestSmartSQSConsumer_TwoInstancesRunIndependently(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockQueueA := NewMockSQSAPI(ctrl)
+	mockLoggerA := NewMockLogger(ctrl)
+	mockMessageConsumerA := NewMockSQSMessageConsumer(ctrl)
+
+	mockQueueB := NewMockSQSAPI(ctrl)
+	mockLoggerB := NewMockLogger(ctrl)
+	mockMessageConsumerB := NewMockSQSMessageConsumer(ctrl)
+
+	consumerA := &SmartSQSConsumer{
+		Logger:          mockLoggerA,
+		QueueURL:        queueURL,
+		Queue:           mockQueueA,
+		MessageConsumer: mockMessageConsumerA,
+		NumWorkers:      2,
+		MessagePoolSize: 5,
+	}
+	consumerB := &SmartSQSConsumer{
+		Logger:          mockLoggerB,
+		QueueURL:        queueURL,
+		Queue:           mockQueueB,
+		MessageConsumer: mockMessageConsumerB,
+		NumWorkers:      2,
+		MessagePoolSize: 5,
+	}
+
+	receiveMessageOutput := &sqs.ReceiveMessageOutput{
+		Messages: []*sqs.Message{defaultSQSMessage},
+	}
+	mockQueueA.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(receiveMessageOutput, nil).Times(1)
+	mockQueueA.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
+	mockQueueB.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(receiveMessageOutput, nil).Times(1)
+	mockQueueB.EXPECT().ReceiveMessageWithContext(gomock.Any(), smartSQSInput).Return(sqsEmptyMessageOutput, nil).AnyTimes()
+
+	var testBlocker sync.WaitGroup
+	testBlocker.Add(2)
+	mockMessageConsumerA.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).Return(nil).Times(1)
+	mockMessageConsumerB.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).Return(nil).Times(1)
+	mockQueueA.EXPECT().DeleteMessage(gomock.Any()).DoAndReturn(func(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+		testBlocker.Done()
+		return &sqs.DeleteMessageOutput{}, nil
+	}).Times(1)
+	mockQueueB.EXPECT().DeleteMessage(gomock.Any()).DoAndReturn(func(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+		testBlocker.Done()
+		return &sqs.DeleteMessageOutput{}, nil
+	}).Times(1)
+
+	go consumerA.StartConsuming(context.Background())
+	go consumerB.StartConsuming(context.Background())
+	testBlocker.Wait()
+
+	var stopBlocker sync.WaitGroup
+	stopBlocker.Add(2)
+	go func() {
+		defer stopBlocker.Done()
+		consumerA.StopConsuming(context.Background())
+	}()
+	go func() {
+		defer stopBlocker.Done()
+		consumerB.StopConsuming(context.Background())
+	}()
+	stopBlocker.Wait()
+}
+
+// TestSmartSQSConsumer_Worker_RetryableError_ExtendsVisibilityOnlyOnce tests that a
+// RetryableConsumerError results in exactly one ChangeMessageVisibility call and no
+// DeleteMessage call. worker previously called its error-handling branch and then
+// unconditionally deleted the message anyway, deleting messages that were meant to be
+// retried.
+func TestSmartSQSConsumer_Worker_RetryableError_ExtendsVisibilityOnlyOnce(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+	mockMessageConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	var consumer = &SmartSQSConsumer{
+		QueueURL:        queueURL,
+		Queue:           mockQueue,
+		MessageConsumer: mockMessageConsumer,
+	}
+
+	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).
+		Return(RetryableConsumerError{VisibilityTimeout: 30})
+	mockQueue.EXPECT().ChangeMessageVisibility(gomock.Any()).Return(&sqs.ChangeMessageVisibilityOutput{}, nil).Times(1)
+	mockQueue.EXPECT().DeleteMessage(gomock.Any()).Times(0)
+
+	messages := make(chan *sqs.Message, 1)
+	messages <- defaultSQSMessage
+	close(messages)
+	consumer.worker(context.Background(), messages)
+}
+
+// TestSmartSQSConsumer_Worker_NonRetryableError_DeletesOnlyOnce tests that a
+// non-retryable error results in exactly one DeleteMessage call, not two.
+func TestSmartSQSConsumer_Worker_NonRetryableError_DeletesOnlyOnce(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockQueue := NewMockSQSAPI(ctrl)
+	mockLogger := NewMockLogger(ctrl)
+	mockMessageConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	var consumer = &SmartSQSConsumer{
+		Logger:          mockLogger,
+		QueueURL:        queueURL,
+		Queue:           mockQueue,
+		MessageConsumer: mockMessageConsumer,
+	}
+
+	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).
+		Return(errors.New("boom"))
+	mockQueue.EXPECT().DeleteMessage(gomock.Any()).Return(&sqs.DeleteMessageOutput{}, nil).Times(1)
+
+	messages := make(chan *sqs.Message, 1)
+	messages <- defaultSQSMessage
+	close(messages)
+	consumer.worker(context.Background(), messages)
+}
+
+// TestSmartSQSConsumer_Worker_UseBatchDelete_RetryableError_EnqueuesVisibilityOnlyOnce
+// is the UseBatchDelete analogue: a RetryableConsumerError must enqueue exactly one
+// visibility-change entry and no delete entry.
+func TestSmartSQSConsumer_Worker_UseBatchDelete_RetryableError_EnqueuesVisibilityOnlyOnce(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockMessageConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	var consumer = &SmartSQSConsumer{
+		QueueURL:        queueURL,
+		MessageConsumer: mockMessageConsumer,
+		UseBatchDelete:  true,
+	}
+
+	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).
+		Return(RetryableConsumerError{VisibilityTimeout: 30})
+
+	messages := make(chan *sqs.Message, 1)
+	messages <- defaultSQSMessage
+	close(messages)
+	consumer.worker(context.Background(), messages)
+
+	assert.Equal(t, len(consumer.pendingVisibility), 1)
+	assert.Equal(t, len(consumer.pendingDeletes), 0)
+}
+
+// TestSmartSQSConsumer_Worker_UseBatchDelete_Success_EnqueuesDeleteOnlyOnce is the
+// UseBatchDelete analogue for the success path: ConsumeMessage returning nil must
+// enqueue exactly one delete entry.
+func TestSmartSQSConsumer_Worker_UseBatchDelete_Success_EnqueuesDeleteOnlyOnce(t *testing.T) {
+	var ctrl = gomock.NewController(t)
+	defer ctrl.Finish()
+	mockMessageConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	var consumer = &SmartSQSConsumer{
+		QueueURL:        queueURL,
+		MessageConsumer: mockMessageConsumer,
+		UseBatchDelete:  true,
+	}
+
+	mockMessageConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte(*defaultSQSMessage.Body)).Return(nil)
+
+	messages := make(chan *sqs.Message, 1)
+	messages <- defaultSQSMessage
+	close(messages)
+	consumer.worker(context.Background(), messages)
+
+	assert.Equal(t, len(consumer.pendingDeletes), 1)
+	assert.Equal(t, len(consumer.pendingVisibility), 0)
+}
+
+// TestVisibilityTimeoutSeconds tests that sub-second heartbeat extensions round up to a
+// 1-second floor instead of truncating to 0, which would make a message immediately
+// visible to other consumers instead of extending its visibility.
+func TestVisibilityTimeoutSeconds(t *testing.T) {
+	cases := []struct {
+		in   time.Duration
+		want int64
+	}{
+		{in: 100 * time.Millisecond, want: 1},
+		{in: 900 * time.Millisecond, want: 1},
+		{in: 1 * time.Second, want: 1},
+		{in: 1500 * time.Millisecond, want: 2},
+		{in: 30 * time.Second, want: 30},
+	}
+	for _, c := range cases {
+		if got := visibilityTimeoutSeconds(c.in); got != c.want {
+			t.Fatalf("visibilityTimeoutSeconds(%s) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package runsqs
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sqsMessageAttributeCarrier adapts SQS MessageAttributes to a propagation.TextMapCarrier
+// so a trace context can be extracted from them. It's read-only: Set is a no-op because
+// this package never needs to inject attributes back onto an already-received message.
+type sqsMessageAttributeCarrier map[string]*sqs.MessageAttributeValue
+
+func (c sqsMessageAttributeCarrier) Get(key string) string {
+	v, ok := c[key]
+	if !ok || v.StringValue == nil {
+		return ""
+	}
+	return *v.StringValue
+}
+
+func (c sqsMessageAttributeCarrier) Set(key, value string) {}
+
+func (c sqsMessageAttributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// WithOpenTelemetryTracing returns a Decorator that extracts a trace context from the
+// message's traceparent/tracestate MessageAttributes (when present) and starts a span
+// named spanName, via tracer, around ConsumeMessage. Extraction uses the global
+// propagator from otel.GetTextMapPropagator, which extracts nothing until one is
+// registered with otel.SetTextMapPropagator.
+func WithOpenTelemetryTracing(tracer trace.Tracer, spanName string) Decorator {
+	return func(next SQSMessageConsumer) SQSMessageConsumer {
+		return &tracingConsumer{next: next, tracer: tracer, spanName: spanName}
+	}
+}
+
+type tracingConsumer struct {
+	next     SQSMessageConsumer
+	tracer   trace.Tracer
+	spanName string
+}
+
+func (c *tracingConsumer) ConsumeMessage(ctx context.Context, message []byte) error {
+	if msgCtx, ok := MessageContextFromContext(ctx); ok {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, sqsMessageAttributeCarrier(msgCtx.MessageAttributes))
+	}
+	ctx, span := c.tracer.Start(ctx, c.spanName)
+	defer span.End()
+
+	e := c.next.ConsumeMessage(ctx, message)
+	if e != nil {
+		span.RecordError(e)
+	}
+	return e
+}
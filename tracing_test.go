@@ -0,0 +1,34 @@
+package runsqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/golang/mock/gomock"
+	"go.opentelemetry.io/otel/trace"
+	"gotest.tools/assert"
+)
+
+func TestWithOpenTelemetryTracing_PassesThroughAndRecordsError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockConsumer := NewMockSQSMessageConsumer(ctrl)
+
+	tracer := trace.NewNoopTracerProvider().Tracer("runsqs-test")
+	decorated := WithOpenTelemetryTracing(tracer, "consume-message")(mockConsumer)
+
+	ctx := withMessageContext(context.Background(), MessageContext{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"traceparent": {DataType: aws.String("String"), StringValue: aws.String("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+		},
+	})
+
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("hello")).Return(nil)
+	assert.NilError(t, decorated.ConsumeMessage(ctx, []byte("hello")))
+
+	mockConsumer.EXPECT().ConsumeMessage(gomock.Any(), []byte("boom")).Return(errors.New("boom"))
+	assert.ErrorContains(t, decorated.ConsumeMessage(ctx, []byte("boom")), "boom")
+}
@@ -0,0 +1,95 @@
+package runsqs
+
+import (
+	"context"
+	"time"
+
+	logger "github.com/asecurityteam/logevent"
+)
+
+// ExtendTimeout lets a message handler ask for more time before its message becomes
+// visible to other consumers again. It is only meaningful when the consumer processing
+// the message has VisibilityHeartbeat configured.
+type ExtendTimeout func(visibilityTimeout time.Duration) error
+
+type extendTimeoutKey struct{}
+
+// withExtendTimeout returns a child context carrying extend, retrievable with
+// ExtendTimeoutFromContext.
+func withExtendTimeout(ctx context.Context, extend ExtendTimeout) context.Context {
+	return context.WithValue(ctx, extendTimeoutKey{}, extend)
+}
+
+// ExtendTimeoutFromContext returns the ExtendTimeout function a consumer stashed on
+// ctx, or nil if none is present (VisibilityHeartbeat is unset, or the caller isn't a
+// message handler invoked by this package).
+func ExtendTimeoutFromContext(ctx context.Context) ExtendTimeout {
+	extend, _ := ctx.Value(extendTimeoutKey{}).(ExtendTimeout)
+	return extend
+}
+
+// SQSMessageConsumerWithExtend is an SQSMessageConsumer that would rather receive its
+// ExtendTimeout callback as a parameter than pull it out of ctx with
+// ExtendTimeoutFromContext. If a SmartSQSConsumer's MessageConsumer implements this
+// interface, it's used in place of ConsumeMessage; DefaultSQSQueueConsumer always calls
+// ConsumeMessage.
+type SQSMessageConsumerWithExtend interface {
+	ConsumeMessageWithExtend(ctx context.Context, message []byte, extend ExtendTimeout) error
+}
+
+// visibilityExtender keeps a single in-flight message invisible to other consumers by
+// periodically extending its visibility timeout for as long as its handler is running.
+type visibilityExtender struct {
+	heartbeat     time.Duration
+	maxVisibility time.Duration
+	extend        ExtendTimeout
+	logger        logger.Logger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newVisibilityExtender starts a goroutine that calls extend every heartbeat, setting
+// the visibility timeout to twice the heartbeat each time, until Stop is called or, if
+// maxVisibility is non-zero, until that much total time has elapsed.
+func newVisibilityExtender(heartbeat, maxVisibility time.Duration, extend ExtendTimeout, log logger.Logger) *visibilityExtender {
+	v := &visibilityExtender{
+		heartbeat:     heartbeat,
+		maxVisibility: maxVisibility,
+		extend:        extend,
+		logger:        log,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go v.run()
+	return v
+}
+
+func (v *visibilityExtender) run() {
+	defer close(v.done)
+	ticker := time.NewTicker(v.heartbeat)
+	defer ticker.Stop()
+	var elapsed time.Duration
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+			elapsed += v.heartbeat
+			if v.maxVisibility > 0 && elapsed >= v.maxVisibility {
+				return
+			}
+			// extension failures are logged but don't fail the message; the handler
+			// keeps running and the message simply risks becoming visible again.
+			if e := v.extend(v.heartbeat * 2); e != nil {
+				v.logger.Error(e.Error())
+			}
+		}
+	}
+}
+
+// Stop cancels the heartbeat and waits for its goroutine to exit.
+func (v *visibilityExtender) Stop() {
+	close(v.stop)
+	<-v.done
+}
@@ -0,0 +1,41 @@
+package runsqs
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVisibilityExtender_ExtendsUntilStopped(t *testing.T) {
+	var calls int64
+	extender := newVisibilityExtender(5*time.Millisecond, 0, func(timeout time.Duration) error {
+		atomic.AddInt64(&calls, 1)
+		return nil
+	}, nil)
+
+	time.Sleep(35 * time.Millisecond)
+	extender.Stop()
+
+	if got := atomic.LoadInt64(&calls); got < 3 {
+		t.Fatalf("expected at least 3 extensions, got %d", got)
+	}
+}
+
+func TestVisibilityExtender_StopsAtMaxVisibility(t *testing.T) {
+	var calls int64
+	extender := newVisibilityExtender(5*time.Millisecond, 15*time.Millisecond, func(timeout time.Duration) error {
+		atomic.AddInt64(&calls, 1)
+		return nil
+	}, nil)
+
+	time.Sleep(50 * time.Millisecond)
+	extender.Stop()
+
+	got := atomic.LoadInt64(&calls)
+	if got == 0 {
+		t.Fatal("expected at least one extension before the cap was hit")
+	}
+	if got > 3 {
+		t.Fatalf("expected extensions to stop once MaxVisibility elapsed, got %d calls", got)
+	}
+}